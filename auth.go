@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Agent-0013/CORgiS-API/config"
+)
+
+// Credentials holds the basic-auth username and bcrypt password hash that
+// guard the control endpoints.
+type Credentials struct {
+	Username     string
+	PasswordHash []byte
+}
+
+// LoadCredentials reads the basic-auth username and bcrypt password hash
+// from cfg (itself filled in from an INI file, a flag, or a CORGIS_AUTH_*
+// environment variable, each overriding the one before; see config.Parse).
+// It returns false if either is unset, meaning auth is unconfigured; main
+// then refuses to start.
+func LoadCredentials(cfg *config.Config) (Credentials, bool) {
+	if cfg.AuthUser == "" || cfg.AuthPassHash == "" {
+		return Credentials{}, false
+	}
+	return Credentials{Username: cfg.AuthUser, PasswordHash: []byte(cfg.AuthPassHash)}, true
+}
+
+// BasicAuth wraps next with an HTTP Basic Auth check against creds. It
+// compares the supplied username in constant time (via a SHA-256 digest,
+// so both operands are always equal length) and verifies the password
+// with bcrypt. On failure it sends a WWW-Authenticate challenge and a
+// 401 response.
+func BasicAuth(creds Credentials, next http.HandlerFunc) http.HandlerFunc {
+	expectedUser := sha256.Sum256([]byte(creds.Username))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if ok {
+			suppliedUser := sha256.Sum256([]byte(user))
+			userMatch := subtle.ConstantTimeCompare(suppliedUser[:], expectedUser[:]) == 1
+			passMatch := bcrypt.CompareHashAndPassword(creds.PasswordHash, []byte(pass)) == nil
+			if userMatch && passMatch {
+				next(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="CORgiS"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("error: unauthorized"))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Agent-0013/CORgiS-API/config"
+)
+
+func testCredentials(t *testing.T, user, pass string) Credentials {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	return Credentials{Username: user, PasswordHash: hash}
+}
+
+func TestBasicAuthCorrectCredentials(t *testing.T) {
+	creds := testCredentials(t, "admin", "hunter2")
+	handler := BasicAuth(creds, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/set", nil)
+	req.SetBasicAuth("admin", "hunter2")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestBasicAuthWrongCredentials(t *testing.T) {
+	creds := testCredentials(t, "admin", "hunter2")
+	handler := BasicAuth(creds, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/set", nil)
+	req.SetBasicAuth("admin", "wrongpass")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("expected WWW-Authenticate header on failure")
+	}
+}
+
+func TestBasicAuthMissingCredentials(t *testing.T) {
+	creds := testCredentials(t, "admin", "hunter2")
+	handler := BasicAuth(creds, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/set", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+}
+
+func TestLoadCredentialsFromConfig(t *testing.T) {
+	cfg := config.Default()
+	cfg.AuthUser = "admin"
+	cfg.AuthPassHash = "$2a$10$examplehash"
+
+	creds, ok := LoadCredentials(cfg)
+	if !ok {
+		t.Fatal("expected LoadCredentials to succeed with both fields set")
+	}
+	if creds.Username != "admin" || string(creds.PasswordHash) != "$2a$10$examplehash" {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+}
+
+func TestLoadCredentialsMissing(t *testing.T) {
+	cfg := config.Default()
+	if _, ok := LoadCredentials(cfg); ok {
+		t.Fatal("expected LoadCredentials to fail when auth is unconfigured")
+	}
+}
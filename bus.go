@@ -0,0 +1,55 @@
+package main
+
+import "sync"
+
+// Sample is one parsed telemetry reading from the graphitizer board.
+type Sample map[string]interface{}
+
+// Bus fans out telemetry samples to any number of subscribers. pollDevice
+// publishes each sample it reads off a device's serial link; the InfluxDB
+// writer and the /stream WebSocket handler are just subscribers like
+// any other.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan Sample]struct{}
+}
+
+// NewBus returns an empty Bus ready to use.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Sample]struct{})}
+}
+
+// Subscribe registers a new subscriber and returns the channel it will
+// receive samples on. The channel is buffered so one slow subscriber
+// doesn't block Publish; callers must Unsubscribe when done.
+func (b *Bus) Subscribe() chan Sample {
+	ch := make(chan Sample, 8)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe removes ch from the bus and closes it.
+func (b *Bus) Unsubscribe(ch chan Sample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Publish sends s to every current subscriber. A subscriber whose
+// buffer is full is skipped for this sample rather than blocking the
+// publisher.
+func (b *Bus) Publish(s Sample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
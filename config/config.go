@@ -0,0 +1,263 @@
+// Package config loads the runtime configuration for the CORgiS API
+// server: HTTP listen port, InfluxDB connection details, the serial
+// link to the graphitizer board, and the set of valid SET parameters.
+// Values come from an optional INI file, overridden by explicitly set
+// command-line flags and then by environment variables, so the binary
+// can be reconfigured without a recompile.
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds every value that main and its helpers previously read
+// from package-level globals or hardcoded constants.
+type Config struct {
+	HTTPPort int
+
+	InfluxHost      string
+	InfluxPort      int
+	DatabaseName    string
+	RetentionPolicy string
+
+	SerialBaudRate    int
+	SerialNumbersPath string
+	PollIntervalMS    int
+
+	VxxParams  []string
+	TxxParams  []string
+	PumpParams []string
+
+	Insecure bool
+	CertPath string
+	KeyPath  string
+
+	// AuthUser and AuthPassHash are the basic-auth username and bcrypt
+	// password hash guarding /set, /start and /stop. Left blank, auth is
+	// unconfigured and main refuses to start.
+	AuthUser     string
+	AuthPassHash string
+}
+
+// Default returns the built-in configuration: the values the server used
+// to hardcode, plus HTTPS with cert.pem/key.pem and no basic-auth
+// credentials.
+func Default() *Config {
+	return &Config{
+		HTTPPort:          9999,
+		InfluxHost:        "localhost",
+		InfluxPort:        8086,
+		DatabaseName:      "data",
+		RetentionPolicy:   "1h",
+		SerialBaudRate:    115200,
+		SerialNumbersPath: "serial_numbers.txt",
+		PollIntervalMS:    1000,
+		VxxParams:         []string{"V00", "V01", "V02", "V03", "V04", "V05", "V06", "V07", "V08"},
+		TxxParams:         []string{"T01", "T02", "T03", "T04", "T05", "T06", "T07", "T08"},
+		PumpParams:        []string{"PUMP_ON", "PUMP_OFF"},
+		Insecure:          false,
+		CertPath:          "cert.pem",
+		KeyPath:           "key.pem",
+	}
+}
+
+// Parse builds a Config from, in increasing priority order: built-in
+// defaults, an optional INI file (--config, or CORGIS_CONFIG), command
+// line flags, and environment variable overrides. It should be called
+// once at the start of main, before the serial port or the database
+// connection are opened.
+func Parse() *Config {
+	cfg := Default()
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CORGIS_CONFIG"), "path to an INI file with configuration overrides")
+	httpPort := fs.Int("http-port", cfg.HTTPPort, "HTTP listen port")
+	influxHost := fs.String("influx-host", cfg.InfluxHost, "InfluxDB host")
+	influxPort := fs.Int("influx-port", cfg.InfluxPort, "InfluxDB port")
+	dbName := fs.String("db-name", cfg.DatabaseName, "InfluxDB database name")
+	retention := fs.String("retention", cfg.RetentionPolicy, "InfluxDB retention policy duration")
+	baudRate := fs.Int("serial-baud", cfg.SerialBaudRate, "serial baud rate")
+	serialNumbersPath := fs.String("serial-numbers", cfg.SerialNumbersPath, "path to the file listing accepted board serial numbers")
+	pollIntervalMS := fs.Int("poll-interval-ms", cfg.PollIntervalMS, "milliseconds between pollDevice polls")
+	insecure := fs.Bool("insecure", cfg.Insecure, "serve plain HTTP instead of HTTPS")
+	certPath := fs.String("cert", cfg.CertPath, "path to the TLS certificate (auto-generated if missing)")
+	keyPath := fs.String("key", cfg.KeyPath, "path to the TLS private key (auto-generated if missing)")
+	vxxParams := fs.String("vxx-params", strings.Join(cfg.VxxParams, ","), "comma-separated list of valid Vxx SET parameters")
+	txxParams := fs.String("txx-params", strings.Join(cfg.TxxParams, ","), "comma-separated list of valid Txx SET parameters")
+	pumpParams := fs.String("pump-params", strings.Join(cfg.PumpParams, ","), "comma-separated list of valid pump SET parameters")
+	authUser := fs.String("auth-user", cfg.AuthUser, "basic-auth username guarding /set, /start and /stop")
+	authPassHash := fs.String("auth-pass-hash", cfg.AuthPassHash, "bcrypt hash of the basic-auth password guarding /set, /start and /stop")
+	fs.Parse(os.Args[1:])
+
+	if *configPath != "" {
+		if err := applyINIFile(cfg, *configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		}
+	}
+
+	// Flags win over the INI file when explicitly set.
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "http-port":
+			cfg.HTTPPort = *httpPort
+		case "influx-host":
+			cfg.InfluxHost = *influxHost
+		case "influx-port":
+			cfg.InfluxPort = *influxPort
+		case "db-name":
+			cfg.DatabaseName = *dbName
+		case "retention":
+			cfg.RetentionPolicy = *retention
+		case "serial-baud":
+			cfg.SerialBaudRate = *baudRate
+		case "serial-numbers":
+			cfg.SerialNumbersPath = *serialNumbersPath
+		case "poll-interval-ms":
+			cfg.PollIntervalMS = *pollIntervalMS
+		case "insecure":
+			cfg.Insecure = *insecure
+		case "cert":
+			cfg.CertPath = *certPath
+		case "key":
+			cfg.KeyPath = *keyPath
+		case "vxx-params":
+			cfg.VxxParams = splitParams(*vxxParams)
+		case "txx-params":
+			cfg.TxxParams = splitParams(*txxParams)
+		case "pump-params":
+			cfg.PumpParams = splitParams(*pumpParams)
+		case "auth-user":
+			cfg.AuthUser = *authUser
+		case "auth-pass-hash":
+			cfg.AuthPassHash = *authPassHash
+		}
+	})
+
+	applyEnvOverrides(cfg)
+
+	return cfg
+}
+
+// applyINIFile reads "key = value" pairs (sections are ignored) from
+// path and applies the ones config recognizes.
+func applyINIFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening ini file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		setField(cfg, key, value)
+	}
+	return scanner.Err()
+}
+
+// applyEnvOverrides lets CORGIS_* environment variables win over both
+// the INI file and the flags, so deployments can override config
+// without touching either.
+func applyEnvOverrides(cfg *Config) {
+	env := map[string]string{
+		"http_port":    os.Getenv("CORGIS_HTTP_PORT"),
+		"influx_host":  os.Getenv("CORGIS_INFLUX_HOST"),
+		"influx_port":  os.Getenv("CORGIS_INFLUX_PORT"),
+		"db_name":      os.Getenv("CORGIS_DB_NAME"),
+		"retention":    os.Getenv("CORGIS_RETENTION"),
+		"serial_baud":  os.Getenv("CORGIS_SERIAL_BAUD"),
+		"serial_numbers":   os.Getenv("CORGIS_SERIAL_NUMBERS"),
+		"poll_interval_ms": os.Getenv("CORGIS_POLL_INTERVAL_MS"),
+		"insecure":         os.Getenv("CORGIS_INSECURE"),
+		"cert":             os.Getenv("CORGIS_CERT"),
+		"key":              os.Getenv("CORGIS_KEY"),
+		"vxx_params":       os.Getenv("CORGIS_VXX_PARAMS"),
+		"txx_params":       os.Getenv("CORGIS_TXX_PARAMS"),
+		"pump_params":      os.Getenv("CORGIS_PUMP_PARAMS"),
+		"auth_user":        os.Getenv("CORGIS_AUTH_USER"),
+		"auth_pass_hash":   os.Getenv("CORGIS_AUTH_PASS_HASH"),
+	}
+	for key, value := range env {
+		if value != "" {
+			setField(cfg, key, value)
+		}
+	}
+}
+
+// setField applies a single key=value pair from either the INI file or
+// the environment to cfg, ignoring unrecognized keys and unparsable
+// values.
+func setField(cfg *Config, key, value string) {
+	switch strings.ToLower(key) {
+	case "http_port", "http-port":
+		if n, err := strconv.Atoi(value); err == nil {
+			cfg.HTTPPort = n
+		}
+	case "influx_host", "influx-host":
+		cfg.InfluxHost = value
+	case "influx_port", "influx-port":
+		if n, err := strconv.Atoi(value); err == nil {
+			cfg.InfluxPort = n
+		}
+	case "db_name", "db-name":
+		cfg.DatabaseName = value
+	case "retention":
+		cfg.RetentionPolicy = value
+	case "serial_baud", "serial-baud":
+		if n, err := strconv.Atoi(value); err == nil {
+			cfg.SerialBaudRate = n
+		}
+	case "serial_numbers", "serial-numbers":
+		cfg.SerialNumbersPath = value
+	case "poll_interval_ms", "poll-interval-ms":
+		if n, err := strconv.Atoi(value); err == nil {
+			cfg.PollIntervalMS = n
+		}
+	case "insecure":
+		if b, err := strconv.ParseBool(value); err == nil {
+			cfg.Insecure = b
+		}
+	case "cert":
+		cfg.CertPath = value
+	case "key":
+		cfg.KeyPath = value
+	case "vxx_params", "vxx-params":
+		cfg.VxxParams = splitParams(value)
+	case "txx_params", "txx-params":
+		cfg.TxxParams = splitParams(value)
+	case "pump_params", "pump-params":
+		cfg.PumpParams = splitParams(value)
+	case "auth_user", "auth-user":
+		cfg.AuthUser = value
+	case "auth_pass_hash", "auth-pass-hash":
+		cfg.AuthPassHash = value
+	}
+}
+
+// splitParams turns a comma-separated flag/INI/env value into a param
+// list, trimming whitespace and dropping empty entries so a trailing
+// comma doesn't produce a bogus "" parameter.
+func splitParams(value string) []string {
+	var params []string
+	for _, p := range strings.Split(value, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
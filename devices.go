@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.bug.st/serial.v1/enumerator"
+
+	"github.com/Agent-0013/CORgiS-API/config"
+	"github.com/Agent-0013/CORgiS-API/serialbroker"
+)
+
+// deviceScanInterval is how often WatchDevices re-scans attached ports
+// for hot-plug/unplug changes.
+const deviceScanInterval = 2 * time.Second
+
+// Device is one graphitizer board currently known to the registry. Its
+// mutable fields are written by the background scan goroutine and read
+// by HTTP handler goroutines, so they're guarded by mu.
+type Device struct {
+	SerialNumber string
+	Broker       *serialbroker.Broker
+
+	mu        sync.RWMutex
+	portName  string
+	lastSeen  time.Time
+	connected bool
+
+	cancel context.CancelFunc
+}
+
+// PortName returns the serial port this device was last seen on.
+func (d *Device) PortName() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.portName
+}
+
+// LastSeen returns the time scanOnce last matched this device's S/N to
+// an attached port.
+func (d *Device) LastSeen() time.Time {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.lastSeen
+}
+
+// Connected reports whether this device's port was present in the most
+// recent scan.
+func (d *Device) Connected() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.connected
+}
+
+func (d *Device) touch() {
+	d.mu.Lock()
+	d.lastSeen = time.Now()
+	d.connected = true
+	d.mu.Unlock()
+}
+
+func (d *Device) markDisconnected() {
+	d.mu.Lock()
+	d.connected = false
+	d.mu.Unlock()
+}
+
+// DeviceRegistry tracks every board the API has seen, keyed by serial
+// number, so one API instance can drive several boards at once.
+type DeviceRegistry struct {
+	mu      sync.RWMutex
+	devices map[string]*Device
+}
+
+// NewDeviceRegistry returns an empty registry.
+func NewDeviceRegistry() *DeviceRegistry {
+	return &DeviceRegistry{devices: make(map[string]*Device)}
+}
+
+// Get returns the device with the given serial number, if known.
+func (r *DeviceRegistry) Get(sn string) (*Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.devices[sn]
+	return d, ok
+}
+
+// List returns every known device, sorted by serial number for stable
+// output.
+func (r *DeviceRegistry) List() []*Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	devices := make([]*Device, 0, len(r.devices))
+	for _, d := range r.devices {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].SerialNumber < devices[j].SerialNumber })
+	return devices
+}
+
+// First returns the device with the lowest serial number among those
+// currently connected, so single-board deployments can omit the device
+// query parameter entirely.
+func (r *DeviceRegistry) First() (*Device, bool) {
+	for _, d := range r.List() {
+		if d.Connected() {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+func (r *DeviceRegistry) put(d *Device) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices[d.SerialNumber] = d
+}
+
+// WatchDevices scans attached USB serial ports every deviceScanInterval,
+// starting a serialbroker.Broker (and a poll-and-publish goroutine) for
+// every newly-seen S/N in cfg.SerialNumbersPath. A board that is
+// unplugged is only marked disconnected; its broker and poller keep
+// running. A board that reappears on a different port has both torn
+// down and started again on the new port. It returns immediately; the
+// scan runs in the background until ctx is done.
+func WatchDevices(ctx context.Context, cfg *config.Config) *DeviceRegistry {
+	registry := NewDeviceRegistry()
+	go func() {
+		ticker := time.NewTicker(deviceScanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				scanOnce(ctx, cfg, registry)
+			}
+		}
+	}()
+	return registry
+}
+
+func scanOnce(ctx context.Context, cfg *config.Config, registry *DeviceRegistry) {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		log.Output(1, fmt.Sprintf("devices: scanning ports: %v", err))
+		return
+	}
+
+	knownSNs := getSerialNumbers(cfg.SerialNumbersPath)
+	seen := make(map[string]bool)
+
+	for _, port := range ports {
+		if !port.IsUSB || !stringInSlice(port.SerialNumber, knownSNs) {
+			continue
+		}
+		seen[port.SerialNumber] = true
+
+		if dev, ok := registry.Get(port.SerialNumber); ok {
+			if dev.PortName() == port.Name {
+				dev.touch()
+				continue
+			}
+			// Reappeared under a different port path: the broker
+			// captured the old path, so it must be rebuilt rather than
+			// just marked seen.
+			dev.cancel()
+			log.Output(1, fmt.Sprintf("devices: %s moved from %s to %s, reconnecting", dev.SerialNumber, dev.PortName(), port.Name))
+		}
+
+		startDevice(ctx, cfg, registry, port.SerialNumber, port.Name)
+	}
+
+	for _, dev := range registry.List() {
+		if !seen[dev.SerialNumber] && dev.Connected() {
+			dev.markDisconnected()
+			log.Output(1, fmt.Sprintf("devices: %s disconnected", dev.SerialNumber))
+		}
+	}
+}
+
+// startDevice opens a serialbroker for sn on portName, registers it,
+// and starts its poll-and-publish goroutine.
+func startDevice(ctx context.Context, cfg *config.Config, registry *DeviceRegistry, sn, portName string) {
+	devCtx, cancel := context.WithCancel(ctx)
+	broker := serialbroker.Start(devCtx, func() string { return portName }, serialMode(cfg), func(s string) bool {
+		return outputIsValid(s, re)
+	})
+	dev := &Device{
+		SerialNumber: sn,
+		Broker:       broker,
+		portName:     portName,
+		lastSeen:     time.Now(),
+		connected:    true,
+		cancel:       cancel,
+	}
+	registry.put(dev)
+	go pollDevice(devCtx, cfg, dev)
+	log.Output(1, fmt.Sprintf("devices: %s connected on %s", sn, portName))
+}
+
+// pollDevice requests a full telemetry frame from dev at cfg's poll
+// interval, caches it, tags it with this device's serial number, and
+// publishes it on telemetryBus. It returns once ctx is done, typically
+// because the board was unplugged or moved to a different port.
+func pollDevice(ctx context.Context, cfg *config.Config, dev *Device) {
+	ticker := time.NewTicker(time.Duration(cfg.PollIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			output, err := dev.Broker.Send(ctx, []byte("<GET_ALL;>"), serialRequestTimeout)
+			if err != nil {
+				log.Output(1, fmt.Sprintf("devices: %s: %v", dev.SerialNumber, err))
+				continue
+			}
+
+			sample := Sample(outputToMap(output))
+			sample["device"] = dev.SerialNumber
+			setLastSample(dev.SerialNumber, sample)
+			telemetryBus.Publish(sample)
+		}
+	}
+}
+
+// singleOutputRead requests one telemetry frame from dev and blocks
+// until the serialbroker returns a valid one.
+func singleOutputRead(ctx context.Context, dev *Device) string {
+	output, err := dev.Broker.Send(ctx, []byte("<GET_ALL;>"), serialRequestTimeout)
+	check(err)
+	return output
+}
+
+// resolveDevice picks the device a /set or /getall request means: the
+// one named by the "device" query parameter, or, if that's absent, the
+// connected device with the lowest serial number (see First).
+func resolveDevice(r *http.Request, registry *DeviceRegistry) (*Device, error) {
+	sn := r.URL.Query().Get("device")
+	if sn == "" {
+		dev, ok := registry.First()
+		if !ok {
+			return nil, fmt.Errorf("no devices connected")
+		}
+		return dev, nil
+	}
+	dev, ok := registry.Get(sn)
+	if !ok {
+		return nil, fmt.Errorf("unknown device: %s", sn)
+	}
+	return dev, nil
+}
+
+// deviceInfo is the JSON shape returned by DevicesHandler.
+type deviceInfo struct {
+	SerialNumber string    `json:"serial_number"`
+	PortName     string    `json:"port_name"`
+	LastSeen     time.Time `json:"last_seen"`
+	Connected    bool      `json:"connected"`
+}
+
+// DevicesHandler returns every device the registry has seen, their port
+// names, last-seen timestamps, and connection health.
+func DevicesHandler(w http.ResponseWriter, r *http.Request) {
+	devices := registry.List()
+	infos := make([]deviceInfo, 0, len(devices))
+	for _, d := range devices {
+		infos = append(infos, deviceInfo{
+			SerialNumber: d.SerialNumber,
+			PortName:     d.PortName(),
+			LastSeen:     d.LastSeen(),
+			Connected:    d.Connected(),
+		})
+	}
+	jsonString, err := json.Marshal(infos)
+	check(err)
+	w.Write(jsonString)
+}
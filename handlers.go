@@ -17,41 +17,44 @@ func SetHandler(w http.ResponseWriter, r *http.Request) {
 	value := r.URL.Query().Get("value")
 
 	// make sure, that param & value combination is valid
-	if !URLParamValid(param) {
+	if !URLParamValid(appConfig, param) {
 		w.Write([]byte("error: incorrect param!"))
 		log.Output(1, "Invalid request!")
 		return
 	}
-	if !URLValueValid(param, value) {
+	if !URLValueValid(appConfig, param, value) {
 		w.Write([]byte("error: incorrect value!"))
 		log.Output(1, "Invalid request!")
 		return
 	}
 
+	dev, err := resolveDevice(r, registry)
+	if err != nil {
+		w.Write([]byte(fmt.Sprintf("error: %v", err)))
+		log.Output(1, err.Error())
+		return
+	}
+
 	// format and send a command to the device
 	command := ""
 	if strings.HasPrefix(param, "PUMP") {
 		command = "<" + param + ";>"
-		_, err := arduino.Write([]byte(command))
-		check(err)
-		log.Output(1, fmt.Sprintf("Command sent: %v", command))
 	} else {
 		command = "<SET_" + param + "=" + value + ";>"
-		_, err := arduino.Write([]byte(command))
-		if err != nil {
-			w.Write([]byte("error: could not send a command to device, check if connected!"))
-		}
-		log.Output(1, fmt.Sprintf("Command sent: %v", command))
 	}
-
-	time.Sleep(30 * time.Millisecond)
+	if _, err := dev.Broker.Send(r.Context(), []byte(command), serialRequestTimeout); err != nil {
+		w.Write([]byte("error: could not send a command to device, check if connected!"))
+		log.Output(1, fmt.Sprintf("Command failed: %v, err: %v", command, err))
+		return
+	}
+	log.Output(1, fmt.Sprintf("Command sent: %v", command))
 
 	// format and send a response depending on parameter
 	if stringInSlice(param, VxxParams) {
 		valueToInt, err := strconv.ParseInt(value, 10, 64)
 		check(err)
 		for {
-			answer := outputToMap(singleOutputRead())
+			answer := outputToMap(singleOutputRead(r.Context(), dev))
 			if answer[param] == valueToInt {
 				jsonString, err := json.Marshal(answer)
 				check(err)
@@ -66,7 +69,7 @@ func SetHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	} else if stringInSlice(param, pumpParams) {
 		for {
-			answer := outputToMap(singleOutputRead())
+			answer := outputToMap(singleOutputRead(r.Context(), dev))
 			if param == "PUMP_ON" && answer["PUMP"] == int64(1) {
 				jsonString, err := json.Marshal(answer)
 				check(err)
@@ -87,7 +90,7 @@ func SetHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		// temperature is inertical, so it doesn't really need imediate response
 	} else if stringInSlice(param, TxxParams) {
-		answer := outputToMap(singleOutputRead())
+		answer := outputToMap(singleOutputRead(r.Context(), dev))
 		jsonString, err := json.Marshal(answer)
 		check(err)
 		w.Write([]byte(jsonString))
@@ -100,7 +103,17 @@ func SetHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetHandler(w http.ResponseWriter, r *http.Request) {
-	answer := outputToMap(singleOutputRead())
+	dev, err := resolveDevice(r, registry)
+	if err != nil {
+		w.Write([]byte(fmt.Sprintf("error: %v", err)))
+		log.Output(1, err.Error())
+		return
+	}
+
+	answer, ok := cachedSample(dev.SerialNumber)
+	if !ok {
+		answer = Sample(outputToMap(singleOutputRead(r.Context(), dev)))
+	}
 	jsonString, err := json.Marshal(answer)
 	check(err)
 	w.Write([]byte(jsonString))
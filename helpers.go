@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Agent-0013/CORgiS-API/config"
 )
 
 // Validates raw arduino output against regex pattern and few other conditions.
@@ -52,29 +54,29 @@ func stringInSlice(a string, list []string) bool {
 	return false
 }
 
-// validates provided url value
-func URLValueValid(p string, v string) bool {
+// validates provided url value against the param sets in cfg
+func URLValueValid(cfg *config.Config, p string, v string) bool {
 	if len(v) > 0 {
 		valueToInt, err := strconv.ParseInt(v, 10, 64)
 		if err != nil {
 			return false
 		}
-		if stringInSlice(p, VxxParams) && valueToInt >= 0 && valueToInt < 256 {
+		if stringInSlice(p, cfg.VxxParams) && valueToInt >= 0 && valueToInt < 256 {
 			return true
-		} else if stringInSlice(p, TxxParams) && valueToInt >= 0 && valueToInt < 1000 {
+		} else if stringInSlice(p, cfg.TxxParams) && valueToInt >= 0 && valueToInt < 1000 {
 			return true
 		}
-	} else if stringInSlice(p, pumpParams) {
+	} else if stringInSlice(p, cfg.PumpParams) {
 		return true
 	}
 	return false
 }
 
-// validates provided URL param
-func URLParamValid(s string) bool {
-	if stringInSlice(s, VxxParams) ||
-		stringInSlice(s, TxxParams) ||
-		stringInSlice(s, pumpParams) {
+// validates provided URL param against the param sets in cfg
+func URLParamValid(cfg *config.Config, s string) bool {
+	if stringInSlice(s, cfg.VxxParams) ||
+		stringInSlice(s, cfg.TxxParams) ||
+		stringInSlice(s, cfg.PumpParams) {
 		return true
 	}
 	return false
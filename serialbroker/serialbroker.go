@@ -0,0 +1,185 @@
+// Package serialbroker arbitrates access to the serial link to the
+// graphitizer board. Before this package existed, the package-level
+// arduino port in main was written to from the telemetry poll loop, SetHandler,
+// singleOutputRead and the reconnect path concurrently, so a scanner
+// re-created per call could hand one caller another caller's response
+// frame. A single owner goroutine now holds the port and a persistent
+// scanner, and every caller pushes a Request and blocks on its Reply
+// channel instead of touching the port directly.
+package serialbroker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"go.bug.st/serial.v1"
+)
+
+// Request is one write-then-wait-for-a-valid-frame round trip.
+type Request struct {
+	Cmd      []byte
+	Reply    chan Reply
+	Deadline time.Time
+}
+
+// Reply carries the frame the owner goroutine matched for a Request, or
+// the error that stopped it from finding one.
+type Reply struct {
+	Data string
+	Err  error
+}
+
+// Broker owns the serial port and services Requests one at a time.
+type Broker struct {
+	requests chan Request
+}
+
+// Start opens the serial port named by findPort and launches the owner
+// goroutine, returning a Broker ready to accept Send calls. The owner
+// exits, closing the port, when ctx is done.
+func Start(ctx context.Context, findPort func() string, mode *serial.Mode, isValid func(string) bool) *Broker {
+	return startWithOpener(ctx, func() (io.ReadWriteCloser, error) {
+		return serial.Open(findPort(), mode)
+	}, isValid)
+}
+
+// startWithOpener is Start with the port-opening step abstracted behind
+// opener, so tests and benchmarks can substitute an in-memory port
+// instead of a real serial line.
+func startWithOpener(ctx context.Context, opener func() (io.ReadWriteCloser, error), isValid func(string) bool) *Broker {
+	b := &Broker{requests: make(chan Request)}
+	go b.run(ctx, opener, isValid)
+	return b
+}
+
+// Send writes cmd and blocks until the owner goroutine has matched the
+// next valid frame, timeout has elapsed, or ctx is done.
+func (b *Broker) Send(ctx context.Context, cmd []byte, timeout time.Duration) (string, error) {
+	reply := make(chan Reply, 1)
+	req := Request{Cmd: cmd, Reply: reply, Deadline: time.Now().Add(timeout)}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case b.requests <- req:
+	case <-timer.C:
+		return "", fmt.Errorf("serialbroker: timed out waiting for the port")
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	select {
+	case r := <-reply:
+		return r.Data, r.Err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// run is the sole owner of the serial port. It reopens the port with
+// exponential backoff whenever a write or read fails, so callers never
+// observe a half-reconnected port.
+func (b *Broker) run(ctx context.Context, opener func() (io.ReadWriteCloser, error), isValid func(string) bool) {
+	port, scanner := open(ctx, opener)
+	if port == nil {
+		return
+	}
+	defer func() {
+		if port != nil {
+			port.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-b.requests:
+			data, err := exchange(port, scanner, req, isValid)
+			if err != nil {
+				port.Close()
+				port, scanner = open(ctx, opener)
+			}
+			req.Reply <- Reply{Data: data, Err: err}
+			if port == nil {
+				return
+			}
+		}
+	}
+}
+
+// open blocks until opener succeeds, doubling its wait between attempts
+// up to 30 seconds. It returns a nil port if ctx is done first.
+func open(ctx context.Context, opener func() (io.ReadWriteCloser, error)) (io.ReadWriteCloser, *bufio.Scanner) {
+	backoff := time.Second
+	for {
+		port, err := opener()
+		if err == nil {
+			return port, bufio.NewScanner(port)
+		}
+		log.Printf("serialbroker: open failed: %v, retrying in %v", err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// scanResult carries the outcome of one scanner.Scan call back from the
+// goroutine reading it.
+type scanResult struct {
+	line string
+	ok   bool
+}
+
+// exchange writes req.Cmd and scans frames until one passes isValid, the
+// deadline passes, or the scanner itself fails.
+//
+// go.bug.st/serial.v1 gives Read no way to honor a deadline mid-call, so
+// scanner.Scan() can block forever against an unresponsive board. Doing
+// that scan directly on run's goroutine would wedge every later Request
+// for this device once one board stops answering. Instead each Scan is
+// run on its own goroutine and raced against the deadline with select;
+// if the deadline wins, exchange returns and leaves the scan goroutine
+// to finish (or never finish) on its own. The caller then reopens the
+// port, so the abandoned goroutine's scanner is discarded with it.
+func exchange(port io.ReadWriteCloser, scanner *bufio.Scanner, req Request, isValid func(string) bool) (string, error) {
+	if _, err := port.Write(req.Cmd); err != nil {
+		return "", fmt.Errorf("serialbroker: write: %w", err)
+	}
+
+	results := make(chan scanResult, 1)
+	scanOne := func() {
+		ok := scanner.Scan()
+		results <- scanResult{line: scanner.Text(), ok: ok}
+	}
+
+	var timeout <-chan time.Time
+	if !req.Deadline.IsZero() {
+		timer := time.NewTimer(time.Until(req.Deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	go scanOne()
+	for {
+		select {
+		case <-timeout:
+			return "", fmt.Errorf("serialbroker: timed out waiting for a valid frame")
+		case res := <-results:
+			if !res.ok {
+				return "", fmt.Errorf("serialbroker: scan failed: %w", scanner.Err())
+			}
+			if isValid(res.line) {
+				return res.line, nil
+			}
+			go scanOne()
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	client "github.com/influxdata/influxdb1-client"
+	"github.com/gorilla/websocket"
+
+	"github.com/Agent-0013/CORgiS-API/config"
+)
+
+// telemetryBus fans out every sample pollDevice parses off a device's
+// serial link to whoever is listening: the InfluxDB writer and any
+// number of /stream clients.
+var telemetryBus = NewBus()
+
+var lastSampleMu sync.RWMutex
+var lastSampleByDevice = make(map[string]Sample)
+
+// setLastSample caches sample as sn's most recent reading, so GetHandler
+// can answer /getall without a fresh serial round-trip.
+func setLastSample(sn string, sample Sample) {
+	lastSampleMu.Lock()
+	lastSampleByDevice[sn] = sample
+	lastSampleMu.Unlock()
+}
+
+// cachedSample returns the most recent sample published for sn, if one
+// has arrived yet.
+func cachedSample(sn string) (Sample, bool) {
+	lastSampleMu.RLock()
+	defer lastSampleMu.RUnlock()
+	sample, ok := lastSampleByDevice[sn]
+	return sample, ok
+}
+
+// startInfluxWriter subscribes to telemetryBus and writes every sample
+// it receives to InfluxDB, so the database write no longer sits on the
+// same synchronous path as the serial read.
+func startInfluxWriter(cfg *config.Config, con *client.Client) {
+	ch := telemetryBus.Subscribe()
+	for sample := range ch {
+		writeLineToDatabase(cfg, con, sample)
+	}
+}
+
+var streamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// StreamHandler upgrades the request to a WebSocket and forwards every
+// telemetry sample published on telemetryBus as JSON until the client
+// disconnects. An optional "device" query parameter restricts the
+// stream to samples from that serial number.
+func StreamHandler(w http.ResponseWriter, r *http.Request) {
+	sn := r.URL.Query().Get("device")
+
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Output(1, "stream: upgrade failed: "+err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ch := telemetryBus.Subscribe()
+	defer telemetryBus.Unsubscribe(ch)
+
+	// gorilla/websocket needs something reading the connection to process
+	// control frames (ping/pong, close) and to notice the client went
+	// away; the write loop below never reads, so without this pump a
+	// disconnect is only caught on the next failed WriteMessage.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		// Selecting on closed here, rather than checking it between
+		// blocking receives from ch, matters when ch never fires: no
+		// device connected yet, or the "device" filter never matches.
+		// A check between receives only runs once a sample arrives, so
+		// it would never notice the client left and would leak this
+		// subscription and goroutine for good.
+		select {
+		case <-closed:
+			return
+		case sample, ok := <-ch:
+			if !ok {
+				return
+			}
+			if sn != "" && sample["device"] != sn {
+				continue
+			}
+			jsonString, err := json.Marshal(sample)
+			check(err)
+			if err := conn.WriteMessage(websocket.TextMessage, jsonString); err != nil {
+				return
+			}
+		}
+	}
+}